@@ -0,0 +1,387 @@
+package Octopus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// DefaultBootstrapEnvVar carries the JSON-encoded array of listenerDescriptor entries a Bootstrap
+	// hands off to its forked child, one per AddListener call, in the order they were added.
+	DefaultBootstrapEnvVar = "OCTOPUS_LISTENERS"
+)
+
+/*
+ListenerConfig configures a single listener added to a Bootstrap. An empty ListenerConfig yields a plain
+listener; setting CertFile and KeyFile serves that listener over TLS.
+ */
+type ListenerConfig struct {
+	CertFile	string
+	KeyFile		string
+}
+
+/*
+bootstrapEndpoint tracks everything Bootstrap needs to know about one AddListener call: how to create it,
+the live listener and server once Serve has been called, and the address used to look it up again in
+handlers and in an inherited descriptor.
+ */
+type bootstrapEndpoint struct {
+	Network		string
+	Addr		string
+	Cfg			ListenerConfig
+	Listener	net.Listener
+	Server		*http.Server
+}
+
+/*
+Bootstrap lets a single process serve several listeners (TCP, Unix, TLS, any mix) and hand all of them off
+together to a forked child across a SIGHUP/SIGUSR2 restart, the same way GracefulServer does for one. Every
+listener is wrapped the same way a GracefulServer wraps its own, so connection draining, MaxConnections and
+KeepAlivePeriod apply uniformly across all of them.
+ */
+type Bootstrap struct {
+	PID			int
+	endpoints	[]*bootstrapEndpoint
+
+	// GracefulTimeout bounds how long shutDown waits for in-flight connections, across every endpoint, to
+	// drain after each endpoint's http.Server has been asked to shut down. It defaults to
+	// DefaultGracefulTimeout.
+	GracefulTimeout	time.Duration
+
+	// connWG tracks connections accepted through any endpoint's gracefulListener.
+	connWG	sync.WaitGroup
+
+	// PidFile, if set, is written with this process's pid at startup and rewritten to a forked child's
+	// pid as soon as forkChild has started it. Unlike GracefulServer.PidFile, there is no wait for the
+	// child to signal readiness first.
+	PidFile		string
+
+	// MaxConnections caps how many connections each endpoint's listener accepts at once; beyond it,
+	// Accept blocks until one closes. Zero (the default) means unlimited.
+	MaxConnections	int
+
+	// KeepAlivePeriod, if non-zero, enables TCP keepalive on every accepted *net.TCPConn with this
+	// period.
+	KeepAlivePeriod	time.Duration
+
+	// preSignalHooks and postSignalHooks are populated by RegisterSignalHook and run by
+	// runSignalHooks around each signal's built-in handler in handleSignals.
+	preSignalHooks	map[os.Signal][]func()
+	postSignalHooks	map[os.Signal][]func()
+}
+
+/*
+AddListener registers a listener to be created (or imported) when Serve is called. network and addr are
+passed to net.Listen (e.g. "tcp", ":8080" or "unix", "/tmp/admin.sock"); cfg controls whether the listener
+is served over TLS.
+ */
+func (b *Bootstrap) AddListener(network, addr string, cfg ListenerConfig) error {
+	for _, ep := range b.endpoints {
+		if ep.Addr == addr {
+			return fmt.Errorf("a listener for %s has already been added", addr)
+		}
+	}
+
+	b.endpoints = append(b.endpoints, &bootstrapEndpoint{Network: network, Addr: addr, Cfg: cfg})
+
+	return nil
+}
+
+/*
+Serve creates (or imports) every registered listener and starts serving it with the handler registered for
+its address in handlers. It then blocks, handling signals, until the Bootstrap shuts down.
+ */
+func (b *Bootstrap) Serve(handlers map[string]http.Handler) (err error) {
+	if len(b.endpoints) == 0 {
+		return fmt.Errorf("no listeners have been added")
+	}
+
+	err = b.createListeners()
+	if err != nil {
+		log.Fatalf("[ERR] Unable to create listeners: %v.\n", err)
+	}
+
+	for _, ep := range b.endpoints {
+		handler, ok := handlers[ep.Addr]
+		if !ok {
+			return fmt.Errorf("no handler registered for %s", ep.Addr)
+		}
+
+		ep.Server = &http.Server{Addr: ep.Addr, Handler: handler}
+
+		wrapped := b.wrapListener(ep.Listener)
+		if ep.Cfg.CertFile != "" || ep.Cfg.KeyFile != "" {
+			go ep.Server.ServeTLS(wrapped, ep.Cfg.CertFile, ep.Cfg.KeyFile)
+		} else {
+			go ep.Server.Serve(wrapped)
+		}
+	}
+
+	b.PID = syscall.Getpid()
+	log.Printf("[INFO] The bootstrap (%d) has started %d listener(s).\n", b.PID, len(b.endpoints))
+
+	if err := writePidFile(b.PidFile, b.PID); err != nil {
+		log.Printf("[ERR] Unable to write pid file: %v.\n", err)
+	}
+
+	err = b.handleSignals()
+	if err != nil {
+		log.Fatalf("[ERR] The bootstrap has shut down: %v\n", err)
+	}
+
+	log.Printf("[INFO] The bootstrap has shut down.\n")
+
+	return nil
+}
+
+/*
+wrapListener wraps a listener with a gracefulListener tracking b.connWG (and, if b.MaxConnections and
+b.KeepAlivePeriod are set, the connection limiter and keepalive tuning too), leaving the endpoint's
+Listener itself untouched so it can still be handed off to a forked child via createListenerFile.
+ */
+func (b *Bootstrap) wrapListener(l net.Listener) net.Listener {
+	return wrapConnLimitedListener(l, &b.connWG, b.MaxConnections, b.KeepAlivePeriod)
+}
+
+/*
+createListeners creates every registered listener. If DefaultBootstrapEnvVar is set and parses, every
+listener is imported from it, and an error is returned if a registered address has no inherited descriptor
+or its FD cannot be turned into a listener; brand new listeners are created only if the variable is unset
+or malformed.
+ */
+func (b *Bootstrap) createListeners() error {
+	descriptors, err := importBootstrapDescriptors()
+	if err != nil {
+		log.Printf("[INFO] Unable to import listeners from file: %v. Creating new ones.", err)
+		return b.newListeners()
+	}
+
+	for _, ep := range b.endpoints {
+		d, ok := descriptors[ep.Addr]
+		if !ok {
+			return fmt.Errorf("no inherited listener for %s", ep.Addr)
+		}
+
+		f := os.NewFile(uintptr(d.FD), d.Name)
+		if f == nil {
+			return fmt.Errorf("unable to create listener file %s", d.Name)
+		}
+
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+
+		ep.Listener = l
+	}
+
+	log.Println("[INFO] Imported listeners from file.")
+
+	return nil
+}
+
+/*
+newListeners creates a brand new listener for every registered endpoint.
+ */
+func (b *Bootstrap) newListeners() error {
+	for _, ep := range b.endpoints {
+		l, err := net.Listen(ep.Network, ep.Addr)
+		if err != nil {
+			return err
+		}
+
+		ep.Listener = l
+		log.Printf("[INFO] Created a new listener on %s.", ep.Addr)
+	}
+
+	return nil
+}
+
+/*
+importBootstrapDescriptors reads DefaultBootstrapEnvVar and unmarshals it into a map of listenerDescriptor
+keyed by address, so createListeners can match inherited FDs to registered endpoints regardless of order.
+ */
+func importBootstrapDescriptors() (map[string]listenerDescriptor, error) {
+	env := os.Getenv(DefaultBootstrapEnvVar)
+	if env == "" {
+		return nil, fmt.Errorf("%s is not set", DefaultBootstrapEnvVar)
+	}
+
+	var descriptors []listenerDescriptor
+	if err := json.Unmarshal([]byte(env), &descriptors); err != nil {
+		return nil, fmt.Errorf("unable to unmarsh [%s] environment variable", env)
+	}
+
+	byAddr := make(map[string]listenerDescriptor, len(descriptors))
+	for _, d := range descriptors {
+		byAddr[d.Addr] = d
+	}
+
+	return byAddr, nil
+}
+
+/*
+handleSignals handles OS signals for a Bootstrap. SIGHUP and SIGUSR2 fork a child carrying all listeners;
+SIGINT and SIGTERM shut the bootstrap down.
+ */
+func (b *Bootstrap) handleSignals() error {
+	sigChan := make(chan os.Signal, 1024)
+	sigHooks := []os.Signal{syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGUSR2, syscall.SIGINT, syscall.SIGTERM}
+	signal.Notify(sigChan, sigHooks...)
+
+	for {
+		sig := <-sigChan
+		log.Printf("[INFO] Bootstrap (%d) received signal %q.\n", b.PID, sig)
+		b.runSignalHooks(PreSignal, sig)
+		switch sig {
+		case syscall.SIGHUP, syscall.SIGUSR2:
+			err := b.forkChild()
+			if err != nil {
+				log.Printf("[ERR] Unable to fork a child: %v.\n", err)
+				continue
+			}
+			b.runSignalHooks(PostSignal, sig)
+			return b.shutDown()
+		case syscall.SIGINT, syscall.SIGTERM:
+			err := b.shutDown()
+			b.runSignalHooks(PostSignal, sig)
+			return err
+		default:
+			log.Printf("[INFO] The signal %q is not a hooked one, ignored!\n", sig)
+		}
+	}
+}
+
+/*
+RegisterSignalHook registers fn to run whenever b receives sig, either just before (PreSignal) or just
+after (PostSignal) the built-in handler for that signal. See GracefulServer.RegisterSignalHook for details;
+Bootstrap's version behaves identically, just across every endpoint's signal handling at once.
+ */
+func (b *Bootstrap) RegisterSignalHook(position int, sig os.Signal, fn func()) error {
+	switch position {
+	case PreSignal:
+		if b.preSignalHooks == nil {
+			b.preSignalHooks = make(map[os.Signal][]func())
+		}
+		b.preSignalHooks[sig] = append(b.preSignalHooks[sig], fn)
+	case PostSignal:
+		if b.postSignalHooks == nil {
+			b.postSignalHooks = make(map[os.Signal][]func())
+		}
+		b.postSignalHooks[sig] = append(b.postSignalHooks[sig], fn)
+	default:
+		return fmt.Errorf("invalid signal hook position: %d", position)
+	}
+
+	return nil
+}
+
+/*
+runSignalHooks runs every hook registered for sig at position, in registration order.
+ */
+func (b *Bootstrap) runSignalHooks(position int, sig os.Signal) {
+	var hooks map[os.Signal][]func()
+	switch position {
+	case PreSignal:
+		hooks = b.preSignalHooks
+	case PostSignal:
+		hooks = b.postSignalHooks
+	}
+
+	for _, fn := range hooks[sig] {
+		fn()
+	}
+}
+
+/*
+forkChild forks a child, handing off every listener's FD starting at fd 3, in the order they were added,
+and encoding the matching descriptors as a JSON array in DefaultBootstrapEnvVar.
+ */
+func (b *Bootstrap) forkChild() error {
+	descriptors := make([]listenerDescriptor, 0, len(b.endpoints))
+	files := []*os.File{os.Stdin, os.Stdout, os.Stderr}
+
+	for i, ep := range b.endpoints {
+		f, err := createListenerFile(ep.Listener)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		descriptors = append(descriptors, listenerDescriptor{
+			Addr:	ep.Addr,
+			FD:		3 + i,
+			Name:	f.Name(),
+		})
+		files = append(files, f)
+	}
+
+	env, err := json.Marshal(descriptors)
+	if err != nil {
+		return err
+	}
+
+	environment := append(os.Environ(), fmt.Sprintf("%s=%s", DefaultBootstrapEnvVar, string(env)))
+
+	exec, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	execDir := filepath.Dir(exec)
+
+	p, err := os.StartProcess(exec, []string{exec}, &os.ProcAttr{
+		Dir:   execDir,
+		Env:   environment,
+		Files: files,
+		Sys:   &syscall.SysProcAttr{},
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[INFO] Forked child (%v) with %d listener(s).\n", p.Pid, len(b.endpoints))
+
+	if err := writePidFile(b.PidFile, p.Pid); err != nil {
+		log.Printf("[ERR] Unable to rewrite pid file to child (%v): %v.\n", p.Pid, err)
+	}
+
+	return nil
+}
+
+/*
+shutDown shuts down every listener's http.Server with a DefaultAwaitTimeout ceiling, then waits for any
+connections still in flight (e.g. handed off to a forked child) to drain across every endpoint, bounded by
+GracefulTimeout, so the old master does not vanish out from under requests it already accepted. It returns
+the first error encountered shutting down an endpoint's http.Server, if any.
+ */
+func (b *Bootstrap) shutDown() error {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultAwaitTimeout)
+	expired, _ := ctx.Deadline()
+	log.Printf("[INFO] Digesting requests will be timed out at %v", expired.Format("2006-01-02 15:04:05"))
+	defer cancel()
+
+	var firstErr error
+	for _, ep := range b.endpoints {
+		if ep.Server == nil {
+			continue
+		}
+		if err := ep.Server.Shutdown(ctx); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	waitForDrain(&b.connWG, b.GracefulTimeout)
+
+	return firstErr
+}
@@ -0,0 +1,70 @@
+package Octopus
+
+import (
+	"os"
+	"testing"
+)
+
+func TestImportBootstrapDescriptors(t *testing.T) {
+	t.Run("env var not set", func(t *testing.T) {
+		os.Unsetenv(DefaultBootstrapEnvVar)
+
+		if _, err := importBootstrapDescriptors(); err == nil {
+			t.Fatal("expected an error when the env var is unset")
+		}
+	})
+
+	t.Run("malformed JSON", func(t *testing.T) {
+		os.Setenv(DefaultBootstrapEnvVar, "not json")
+		defer os.Unsetenv(DefaultBootstrapEnvVar)
+
+		if _, err := importBootstrapDescriptors(); err == nil {
+			t.Fatal("expected an error for malformed JSON")
+		}
+	})
+
+	t.Run("matches descriptors by address regardless of order", func(t *testing.T) {
+		os.Setenv(DefaultBootstrapEnvVar, `[
+			{"addr": ":8443", "FD": 4, "Name": "b"},
+			{"addr": ":8080", "FD": 3, "Name": "a"}
+		]`)
+		defer os.Unsetenv(DefaultBootstrapEnvVar)
+
+		descriptors, err := importBootstrapDescriptors()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if len(descriptors) != 2 {
+			t.Fatalf("expected 2 descriptors, got %d", len(descriptors))
+		}
+
+		http, ok := descriptors[":8080"]
+		if !ok {
+			t.Fatal("expected a descriptor for :8080")
+		}
+		if http.FD != 3 || http.Name != "a" {
+			t.Errorf(":8080 descriptor = %+v, want FD 3 Name \"a\"", http)
+		}
+
+		https, ok := descriptors[":8443"]
+		if !ok {
+			t.Fatal("expected a descriptor for :8443")
+		}
+		if https.FD != 4 || https.Name != "b" {
+			t.Errorf(":8443 descriptor = %+v, want FD 4 Name \"b\"", https)
+		}
+	})
+}
+
+func TestBootstrapAddListenerRejectsDuplicateAddr(t *testing.T) {
+	b := &Bootstrap{}
+
+	if err := b.AddListener("tcp", ":8080", ListenerConfig{}); err != nil {
+		t.Fatalf("unexpected error on first AddListener: %v", err)
+	}
+
+	if err := b.AddListener("tcp", ":8080", ListenerConfig{}); err == nil {
+		t.Fatal("expected an error when adding a listener for an address already registered")
+	}
+}
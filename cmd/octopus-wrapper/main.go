@@ -0,0 +1,183 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const restartDelay = time.Second
+
+/*
+octopus-wrapper execs a GracefulServer-based binary and supervises it: it re-execs the server on SIGHUP by
+reading its pid file and signalling it, restarts it if it crashes, and forwards SIGINT/SIGTERM so the
+wrapper and the server stop together. A SIGHUP-triggered exit is expected (the server forks its own child
+and shuts itself down) and is not treated as a crash, but it also means the wrapper stops supervising: the
+newly forked child is not its own, so it cannot detect a future crash of that child and exits instead of
+restarting a duplicate with no listener to inherit. It is modeled on gitaly-wrapper and is meant to sit
+under a process supervisor such as systemd or runit, which the server's own master/child forking otherwise
+cannot satisfy.
+ */
+func main() {
+	var pidFile string
+	flag.StringVar(&pidFile, "pidfile", "", "pid file written by the wrapped server (required to re-exec on SIGHUP)")
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) == 0 {
+		log.Fatalf("[ERR] usage: octopus-wrapper [-pidfile path] <server> [args...]\n")
+	}
+
+	w := &wrapper{command: args[0], args: args[1:], pidFile: pidFile}
+	w.run()
+}
+
+type wrapper struct {
+	command string
+	args    []string
+	pidFile string
+	cmd     *exec.Cmd
+
+	// reexecMu guards reexecPending, set by reexec and consumed by run's done case so a clean exit
+	// triggered by our own SIGHUP is not mistaken for a crash and restarted from scratch.
+	reexecMu      sync.Mutex
+	reexecPending bool
+}
+
+/*
+start execs the wrapped server as a child process, wiring its standard streams straight through.
+ */
+func (w *wrapper) start() error {
+	w.cmd = exec.Command(w.command, w.args...)
+	w.cmd.Stdin = os.Stdin
+	w.cmd.Stdout = os.Stdout
+	w.cmd.Stderr = os.Stderr
+
+	return w.cmd.Start()
+}
+
+/*
+run starts the wrapped server and then loops, restarting it on an unexpected exit and re-executing it on
+SIGHUP, until SIGINT or SIGTERM asks the wrapper to stop, or the server exits because of a SIGHUP reexec
+the wrapper itself asked for.
+ */
+func (w *wrapper) run() {
+	sigChan := make(chan os.Signal, 1024)
+	signal.Notify(sigChan, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+
+	if err := w.start(); err != nil {
+		log.Fatalf("[ERR] Unable to start %s: %v.\n", w.command, err)
+	}
+	log.Printf("[INFO] Started %s (%d).\n", w.command, w.cmd.Process.Pid)
+
+	done := w.wait()
+
+	for {
+		select {
+		case sig := <-sigChan:
+			switch sig {
+			case syscall.SIGHUP:
+				log.Printf("[INFO] Received %q, re-executing %s.\n", sig, w.command)
+				if err := w.reexec(); err != nil {
+					log.Printf("[ERR] Unable to re-exec %s: %v.\n", w.command, err)
+				}
+			case syscall.SIGINT, syscall.SIGTERM:
+				log.Printf("[INFO] Received %q, forwarding to %s and stopping.\n", sig, w.command)
+				w.forward(sig)
+				return
+			}
+		case err := <-done:
+			if w.consumeReexecPending() {
+				log.Printf("[INFO] %s (%d) exited after a SIGHUP reload: %v. The forked child is running unsupervised; the wrapper is stopping.\n", w.command, w.cmd.Process.Pid, err)
+				return
+			}
+
+			log.Printf("[INFO] %s (%d) exited: %v. Restarting in %v.\n", w.command, w.cmd.Process.Pid, err, restartDelay)
+			time.Sleep(restartDelay)
+			if err := w.start(); err != nil {
+				log.Fatalf("[ERR] Unable to restart %s: %v.\n", w.command, err)
+			}
+			log.Printf("[INFO] Restarted %s (%d).\n", w.command, w.cmd.Process.Pid)
+			done = w.wait()
+		}
+	}
+}
+
+/*
+wait returns a channel that receives the current child's exit error once it terminates.
+ */
+func (w *wrapper) wait() <-chan error {
+	done := make(chan error, 1)
+	cmd := w.cmd
+	go func() { done <- cmd.Wait() }()
+	return done
+}
+
+/*
+forward relays sig to the wrapped server, if it is still running.
+ */
+func (w *wrapper) forward(sig os.Signal) {
+	if w.cmd != nil && w.cmd.Process != nil {
+		w.cmd.Process.Signal(sig)
+	}
+}
+
+/*
+reexec reads the server's current pid from pidFile and asks it to fork-restart itself with SIGHUP, the
+same signal a user would send by hand. It marks a reexec as pending first, so that when the signalled
+process later exits (it always does: the server forks its own child and then shuts itself down), run's
+done case recognises that clean exit and does not mistake it for a crash.
+ */
+func (w *wrapper) reexec() error {
+	pid, err := readPidFile(w.pidFile)
+	if err != nil {
+		return err
+	}
+
+	w.reexecMu.Lock()
+	w.reexecPending = true
+	w.reexecMu.Unlock()
+
+	if err := syscall.Kill(pid, syscall.SIGHUP); err != nil {
+		w.reexecMu.Lock()
+		w.reexecPending = false
+		w.reexecMu.Unlock()
+		return err
+	}
+
+	return nil
+}
+
+/*
+consumeReexecPending reports whether the current exit was expected because of a prior reexec, clearing the
+flag so the next exit is treated as a crash again unless another reexec sets it.
+ */
+func (w *wrapper) consumeReexecPending() bool {
+	w.reexecMu.Lock()
+	defer w.reexecMu.Unlock()
+
+	pending := w.reexecPending
+	w.reexecPending = false
+
+	return pending
+}
+
+/*
+readPidFile reads and parses a pid file as written by GracefulServer's PidFile option.
+ */
+func readPidFile(path string) (int, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
@@ -0,0 +1,124 @@
+package Octopus
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+/*
+gracefulConn wraps a net.Conn so that the owning GracefulServer can track how many connections are
+currently in flight, and release its slot in the connection limiter (if any). The once field
+makes sure both happen exactly once, even if Close is called more than once by the standard library.
+ */
+type gracefulConn struct {
+	net.Conn
+	wg   *sync.WaitGroup
+	sem  chan struct{}
+	once sync.Once
+}
+
+/*
+Close closes the underlying connection, marks it as done in the listener's WaitGroup, and frees its slot
+in the listener's connection limiter semaphore, if one is configured.
+ */
+func (c *gracefulConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() {
+		c.wg.Done()
+		if c.sem != nil {
+			<-c.sem
+		}
+	})
+	return err
+}
+
+/*
+gracefulListener wraps a net.Listener so that every accepted connection is tracked by a WaitGroup, capped
+by an optional connection limiter, and keepalive-tuned if it is a *net.TCPConn. This lets a GracefulServer
+wait for in-flight connections to finish during a hot reload, and protects it from a restart storm or a
+slow-loris style attack piling up more connections than it can serve.
+ */
+type gracefulListener struct {
+	net.Listener
+	wg              *sync.WaitGroup
+	sem             chan struct{}
+	keepAlivePeriod time.Duration
+	closeOnce       sync.Once
+	done            chan struct{}
+}
+
+/*
+Accept acquires a slot from the connection limiter (blocking if MaxConnections are already in flight),
+accepts a connection, tunes its keepalive if applicable, and registers it with the listener's WaitGroup
+before handing it back to the caller. The semaphore acquire is raced against the listener being closed, so
+a Serve goroutine parked here during a restart storm is released as soon as shutDown closes the listener,
+rather than waiting forever for some other connection to free a slot.
+ */
+func (l *gracefulListener) Accept() (net.Conn, error) {
+	if l.sem != nil {
+		select {
+		case l.sem <- struct{}{}:
+		case <-l.done:
+			return nil, net.ErrClosed
+		}
+	}
+
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		if l.sem != nil {
+			<-l.sem
+		}
+		return nil, err
+	}
+
+	if tc, ok := conn.(*net.TCPConn); ok && l.keepAlivePeriod > 0 {
+		tc.SetKeepAlive(true)
+		tc.SetKeepAlivePeriod(l.keepAlivePeriod)
+	}
+
+	l.wg.Add(1)
+
+	return &gracefulConn{Conn: conn, wg: l.wg, sem: l.sem}, nil
+}
+
+/*
+Close closes the underlying listener and signals done, unblocking any Accept parked on the connection
+limiter's semaphore.
+ */
+func (l *gracefulListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.done)
+	})
+	return l.Listener.Close()
+}
+
+/*
+wrapConnLimitedListener wraps a listener with a gracefulListener tracking wg (and, if maxConnections and
+keepAlivePeriod are set, the connection limiter and keepalive tuning too). It backs both GracefulServer's
+and Bootstrap's wrapListener methods, so a single Serve loop behaves the same whether it is fronting one
+listener or several.
+ */
+func wrapConnLimitedListener(l net.Listener, wg *sync.WaitGroup, maxConnections int, keepAlivePeriod time.Duration) net.Listener {
+	gl := &gracefulListener{
+		Listener:        l,
+		wg:              wg,
+		keepAlivePeriod: keepAlivePeriod,
+		done:            make(chan struct{}),
+	}
+
+	if maxConnections > 0 {
+		gl.sem = make(chan struct{}, maxConnections)
+	}
+
+	return gl
+}
+
+/*
+wrapListener wraps a listener with a gracefulListener tracking srv.connWG (and, if srv.MaxConnections and
+srv.KeepAlivePeriod are set, the connection limiter and keepalive tuning too), leaving srv.Listener itself
+untouched so it can still be handed off to a forked child via createListenerFile.
+ */
+func (srv *GracefulServer) wrapListener(l net.Listener) net.Listener {
+	return wrapConnLimitedListener(l, &srv.connWG, srv.MaxConnections, srv.KeepAlivePeriod)
+}
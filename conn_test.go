@@ -0,0 +1,106 @@
+package Octopus
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestGracefulListenerLimitsConcurrentConnections(t *testing.T) {
+	raw, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to start listener: %v", err)
+	}
+
+	srv := &GracefulServer{MaxConnections: 1}
+	l := srv.wrapListener(raw)
+	defer l.Close()
+
+	accepted := make(chan net.Conn, 8)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- c
+		}
+	}()
+
+	first, err := net.Dial("tcp", raw.Addr().String())
+	if err != nil {
+		t.Fatalf("unable to dial: %v", err)
+	}
+	defer first.Close()
+
+	var firstAccepted net.Conn
+	select {
+	case firstAccepted = <-accepted:
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the first connection to be accepted")
+	}
+
+	second, err := net.Dial("tcp", raw.Addr().String())
+	if err != nil {
+		t.Fatalf("unable to dial: %v", err)
+	}
+	defer second.Close()
+
+	select {
+	case <-accepted:
+		t.Fatal("second connection was accepted while MaxConnections (1) was already in use")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err := firstAccepted.Close(); err != nil {
+		t.Fatalf("unable to close first accepted conn: %v", err)
+	}
+
+	select {
+	case <-accepted:
+	case <-time.After(time.Second):
+		t.Fatal("second connection was never accepted after the first's slot was freed")
+	}
+}
+
+func TestGracefulListenerCloseUnblocksAccept(t *testing.T) {
+	raw, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to start listener: %v", err)
+	}
+
+	srv := &GracefulServer{MaxConnections: 1}
+	l := srv.wrapListener(raw)
+
+	conn, err := net.Dial("tcp", raw.Addr().String())
+	if err != nil {
+		t.Fatalf("unable to dial: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := l.Accept(); err != nil {
+		t.Fatalf("unable to accept the first connection: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := l.Accept()
+		done <- err
+	}()
+
+	// Give the goroutine a chance to block on the full semaphore before closing.
+	time.Sleep(50 * time.Millisecond)
+
+	if err := l.Close(); err != nil {
+		t.Fatalf("unable to close listener: %v", err)
+	}
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("expected Accept to return an error once the listener was closed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Accept stayed blocked on the semaphore after the listener was closed")
+	}
+}
@@ -0,0 +1,126 @@
+package Octopus
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+/*
+Lifecycle states a GracefulServer moves through: StateInit while it is creating/importing its listener,
+StateRunning once it has started serving, StateShuttingDown once shutDown has been asked to stop the
+http.Server, and StateTerminate once it has fully stopped (including draining in-flight connections).
+ */
+const (
+	StateInit = iota
+	StateRunning
+	StateShuttingDown
+	StateTerminate
+)
+
+/*
+DefaultReadyFDEnvVar carries, as a decimal file descriptor number, the write end of a pipe a forked child
+inherits from its parent. Once the child has bound its listener and entered StateRunning, it writes a
+single byte to that fd and closes it, so the parent's forkChild can wait for real readiness instead of
+blindly forking and returning.
+ */
+const DefaultReadyFDEnvVar = "OCTOPUS_READY_FD"
+
+/*
+State returns the server's current lifecycle state (one of the State* constants).
+ */
+func (srv *GracefulServer) State() int {
+	return int(atomic.LoadInt32(&srv.state))
+}
+
+/*
+StateChan returns a channel that receives every state srv transitions into from this point on, starting
+with a synchronous send of srv's current state (so a caller that calls StateChan after a transition already
+happened still observes it, rather than blocking on one that already came and went). It is buffered beyond
+that seed value, and a transition is dropped rather than blocking setState if the channel is full, so a slow
+or absent observer cannot wedge the server's own signal handling.
+
+To observe StateRunning (and everything after it), build srv with StartGraceful (or
+StartGracefulTLS/StartGracefulTLSConfig) rather than GracefulServe/GracefulServeTLS*, call StateChan on
+the result, and only then call Wait — GracefulServe's blocking call graph never returns srv in time to
+read from it.
+ */
+func (srv *GracefulServer) StateChan() <-chan int {
+	srv.stateOnce.Do(func() {
+		srv.stateChan = make(chan int, 8)
+		srv.stateChan <- srv.State()
+	})
+
+	return srv.stateChan
+}
+
+/*
+setState updates the server's lifecycle state and notifies any StateChan observer.
+ */
+func (srv *GracefulServer) setState(s int) {
+	atomic.StoreInt32(&srv.state, int32(s))
+
+	if srv.stateChan != nil {
+		select {
+		case srv.stateChan <- s:
+		default:
+		}
+	}
+}
+
+/*
+signalReady writes a single byte to the ready pipe fd inherited from the parent (named by
+DefaultReadyFDEnvVar), if any, telling a waiting forkChild that this process has entered StateRunning. It
+is a no-op for a process that was not itself forked by this package (e.g. the very first master).
+ */
+func signalReady() {
+	fdStr := os.Getenv(DefaultReadyFDEnvVar)
+	if fdStr == "" {
+		return
+	}
+
+	fd, err := strconv.Atoi(fdStr)
+	if err != nil {
+		return
+	}
+
+	f := os.NewFile(uintptr(fd), "ready-pipe")
+	if f == nil {
+		return
+	}
+	defer f.Close()
+
+	f.Write([]byte{1})
+}
+
+/*
+waitForChildReady blocks until readPipe yields a byte (written by the child's signalReady), until readPipe
+is closed, or until timeout elapses, whichever comes first. Only the byte counts as readiness: a pipe closed
+without one (e.g. the child exited first) and a timeout both return an error. It always closes readPipe
+before returning.
+ */
+func waitForChildReady(readPipe *os.File, timeout time.Duration) error {
+	defer readPipe.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1)
+		n, err := readPipe.Read(buf)
+		if n == 1 {
+			err = nil
+		} else if err == nil || err == io.EOF {
+			err = fmt.Errorf("ready pipe closed before the child signalled readiness")
+		}
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		return fmt.Errorf("timed out after %v waiting for readiness", timeout)
+	}
+}
@@ -2,6 +2,7 @@ package Octopus
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,6 +12,7 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"sync"
 	"syscall"
 	"time"
 )
@@ -19,6 +21,7 @@ const (
 	DefaultEnvVar = "OCTOPUS_LISTENER"
 	DefaultNetwork = "tcp"
 	DefaultAwaitTimeout =  5 * time.Second
+	DefaultGracefulTimeout = 10 * time.Second
 )
 /*
 listenerDescriptor defines a listener descriptor file. A descriptor file is created when a child process is forked.
@@ -43,32 +46,142 @@ type GracefulServer struct {
 	PID  		int
 	Server		*http.Server
 	Listener	net.Listener
+
+	// GracefulTimeout bounds how long shutDown waits for in-flight connections to drain after the
+	// underlying http.Server has been asked to shut down. It defaults to DefaultGracefulTimeout.
+	GracefulTimeout	time.Duration
+
+	// connWG tracks connections accepted through the gracefulListener wrapping Listener.
+	connWG	sync.WaitGroup
+
+	// PidFile, if set, is kept up to date with the pid of whichever process is currently serving:
+	// written at startup, then rewritten to a forked child's pid once it has signalled readiness. This
+	// happens regardless of how the child was forked, so a supervisor such as cmd/octopus-wrapper
+	// always finds the right pid to signal.
+	PidFile		string
+
+	// preSignalHooks and postSignalHooks are populated by RegisterSignalHook and run by
+	// runSignalHooks around each signal's built-in handler in handleSignals.
+	preSignalHooks	map[os.Signal][]func()
+	postSignalHooks	map[os.Signal][]func()
+
+	// state and stateChan back State() and StateChan(); see lifecycle.go.
+	state		int32
+	stateChan	chan int
+	stateOnce	sync.Once
+
+	// MaxConnections caps how many connections the listener accepts at once; beyond it, Accept blocks
+	// until one closes. Zero (the default) means unlimited.
+	MaxConnections	int
+
+	// KeepAlivePeriod, if non-zero, enables TCP keepalive on every accepted *net.TCPConn with this
+	// period.
+	KeepAlivePeriod	time.Duration
 }
 
 /*
-GracefulServe starts a HTTP server. It receives a http.Server server passed by user and an indicators killMaster.
-It first create a listener (either a new one or a imported one). Then it starts a goroutine for the server to start
-accepting connections. Any hooked signals will be handled in handleSignals(...).
+StartGraceful creates (or imports) a listener for server and starts a goroutine accepting connections on
+it, then returns immediately with the live *GracefulServer. Unlike GracefulServe, it does not block on
+signal handling, so the caller has a window to call RegisterSignalHook or start reading StateChan before
+any signal can arrive. Call Wait on the result to block on signal handling once that setup is done.
  */
-func GracefulServe(server *http.Server, killMaster bool) (gs *GracefulServer, err error) {
+func StartGraceful(server *http.Server) (*GracefulServer, error) {
+	srv := &GracefulServer{
+		Addr:   server.Addr,
+		Server: server,
+	}
+
+	if err := srv.start(func(l net.Listener) {
+		go srv.Server.Serve(l)
+	}); err != nil {
+		return nil, err
+	}
 
+	return srv, nil
+}
+
+/*
+StartGracefulTLS is the TLS equivalent of StartGraceful, serving server over HTTPS using the certificate
+and key found at certFile and keyFile. It is a thin convenience wrapper around StartGracefulTLSConfig for
+callers who do not need to supply their own *tls.Config.
+ */
+func StartGracefulTLS(server *http.Server, certFile, keyFile string) (*GracefulServer, error) {
+	return StartGracefulTLSConfig(server, certFile, keyFile, nil)
+}
+
+/*
+StartGracefulTLSConfig is the TLS equivalent of StartGraceful. tlsConfig, if non-nil, is used as the
+server's TLS configuration, letting callers supply SNI-based certificate selection, a GetCertificate hook
+(e.g. for autocert/Let's Encrypt), or preloaded certificates; certFile and keyFile may both be empty in
+that case, as long as tlsConfig already carries certificates. Either way, NextProtos is widened to include
+"h2" and "http/1.1" so HTTP/2 negotiates correctly.
+ */
+func StartGracefulTLSConfig(server *http.Server, certFile, keyFile string, tlsConfig *tls.Config) (*GracefulServer, error) {
 	srv := &GracefulServer{
 		Addr:   server.Addr,
 		Server: server,
 	}
 
-	err = srv.createListener()
+	ensureALPN(server, tlsConfig)
+
+	if err := srv.start(func(l net.Listener) {
+		go srv.Server.ServeTLS(l, certFile, keyFile)
+	}); err != nil {
+		return nil, err
+	}
+
+	return srv, nil
+}
+
+/*
+start creates (or imports) srv's listener, hands the wrapped listener to serve to start accepting
+connections, and brings srv up to StateRunning: recording its pid, writing PidFile, and signalling
+readiness to a parent that forked this process.
+ */
+func (srv *GracefulServer) start(serve func(l net.Listener)) error {
+	err := srv.createListener()
 	if err != nil {
-		log.Fatalf("[ERR] Unable to create a listener: %v.\n", err)
+		return err
 	}
 
-	go srv.Server.Serve(srv.Listener)
+	serve(srv.wrapListener(srv.Listener))
 
-	pid := syscall.Getpid()
-	srv.PID = pid
+	srv.PID = syscall.Getpid()
 	log.Printf("[INFO] The server has started (%d).\n", srv.PID)
 
-	err = srv.handleSignals(killMaster, srv.PID)
+	if err := writePidFile(srv.PidFile, srv.PID); err != nil {
+		log.Printf("[ERR] Unable to write pid file: %v.\n", err)
+	}
+
+	srv.setState(StateRunning)
+	signalReady()
+
+	return nil
+}
+
+/*
+Wait blocks handling signals until the server shuts down. killMaster controls the killing behaviour after
+a child is forked: if true, this process is killed (via its own pid) once the child starts; if false, it
+is left running alongside the child.
+ */
+func (srv *GracefulServer) Wait(killMaster bool) error {
+	return srv.handleSignals(killMaster, srv.PID)
+}
+
+/*
+GracefulServe starts a HTTP server and blocks handling signals until it shuts down. It receives a
+http.Server server passed by user and an indicators killMaster. It is a convenience wrapper combining
+StartGraceful and Wait for callers that do not need access to the live *GracefulServer before it blocks —
+e.g. to call RegisterSignalHook or read StateChan, use StartGraceful and Wait directly instead.
+ */
+func GracefulServe(server *http.Server, killMaster bool) (gs *GracefulServer, err error) {
+	srv, err := StartGraceful(server)
+	if err != nil {
+		log.Fatalf("[ERR] Unable to create a listener: %v.\n", err)
+	}
+	gs = srv
+
+	err = srv.Wait(killMaster)
 	if err != nil {
 		log.Fatalf("[ERR] The server has shut down: %v\n", err)
 	}
@@ -79,32 +192,27 @@ func GracefulServe(server *http.Server, killMaster bool) (gs *GracefulServer, er
 }
 
 /*
-GracefulServeTLS starts a HTTPS server. It receives a http.Server server passed by user and an indicators killMaster.
-It first create a listener (either a new one or a imported one). Then it starts a goroutine for the server to start
-accepting connections. Any hooked signals will be handled in handleSignals(...). Certificate and key are compulsory
-for starting a HTTPS server.
+GracefulServeTLS starts a HTTPS server using the certificate and key found at certFile and keyFile and
+blocks handling signals until it shuts down. It is a thin convenience wrapper around
+GracefulServeTLSConfig for callers who do not need to supply their own *tls.Config.
 */
 func GracefulServeTLS(server *http.Server, killMaster bool, certFile, keyFile string) (err error) {
+	return GracefulServeTLSConfig(server, killMaster, certFile, keyFile, nil)
+}
 
-	srv := &GracefulServer{
-		Addr:   server.Addr,
-		Server: server,
-	}
-
-	err = srv.createListener()
+/*
+GracefulServeTLSConfig starts a HTTPS server and blocks handling signals until it shuts down. It is a
+convenience wrapper combining StartGracefulTLSConfig and Wait for callers that do not need access to the
+live *GracefulServer before it blocks — e.g. to call RegisterSignalHook or read StateChan, use
+StartGracefulTLSConfig and Wait directly instead.
+*/
+func GracefulServeTLSConfig(server *http.Server, killMaster bool, certFile, keyFile string, tlsConfig *tls.Config) (err error) {
+	srv, err := StartGracefulTLSConfig(server, certFile, keyFile, tlsConfig)
 	if err != nil {
 		log.Fatalf("[ERR] Unable to create a listener: %v.\n", err)
 	}
 
-	go srv.Server.ServeTLS(srv.Listener, certFile, keyFile)
-
-	server.Close()
-
-	pid := syscall.Getpid()
-	srv.PID = pid
-	log.Printf("[INFO] The server has started (%d).\n", srv.PID)
-
-	err = srv.handleSignals(killMaster, srv.PID)
+	err = srv.Wait(killMaster)
 	if err != nil {
 		log.Fatalf("[ERR] The server has shut down: %v\n", err)
 	}
@@ -130,6 +238,8 @@ createListener creates a listener on a given address. If a descriptor file is fo
 of it (importListener); otherwise, it creates a new one (newListener).
  */
 func (srv *GracefulServer) createListener() (err error) {
+	srv.setState(StateInit)
+
 	// Check environment variables
 	env := os.Getenv(DefaultEnvVar)
 	if env != "" {
@@ -210,6 +320,7 @@ func (srv *GracefulServer) handleSignals(killMaster bool, mpid int) error {
 	for {
 		sig := <-sigChan
 		log.Printf("[INFO] Server (%d) received signal %q.\n", mpid, sig)
+		srv.runSignalHooks(PreSignal, sig)
 		switch sig {
 		case syscall.SIGHUP:
 			err := srv.forkChild(killMaster, mpid)
@@ -217,6 +328,7 @@ func (srv *GracefulServer) handleSignals(killMaster bool, mpid int) error {
 				log.Printf("[ERR] Unable to fork a child: %v.\n", err)
 				continue
 			}
+			srv.runSignalHooks(PostSignal, sig)
 			return srv.shutDown()
 		case syscall.SIGUSR1,  syscall.SIGUSR2:
 			err := srv.forkChild(killMaster, mpid)
@@ -224,8 +336,11 @@ func (srv *GracefulServer) handleSignals(killMaster bool, mpid int) error {
 				log.Printf("[ERR] Unable to fork a child: %v.\n", err)
 				continue
 			}
+			srv.runSignalHooks(PostSignal, sig)
 		case syscall.SIGINT, syscall.SIGTERM:
-			return srv.shutDown()
+			err := srv.shutDown()
+			srv.runSignalHooks(PostSignal, sig)
+			return err
 
 		default:
 			log.Printf("[INFO] The signal %q is not a hooked one, ignored!\n", sig)
@@ -254,12 +369,20 @@ func (srv *GracefulServer) forkChild(killMaster bool, mpid int) (error) {
 		return err
 	}
 
-	files := []*os.File{os.Stdin, os.Stdout, os.Stderr, f}
+	readPipe, writePipe, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+
+	files := []*os.File{os.Stdin, os.Stdout, os.Stderr, f, writePipe}
 
 	environment := append(os.Environ(), fmt.Sprintf("%s=%s", DefaultEnvVar, string(env)))
+	environment = append(environment, fmt.Sprintf("%s=%d", DefaultReadyFDEnvVar, len(files)-1))
 
 	exec, err := os.Executable()
 	if err != nil {
+		readPipe.Close()
+		writePipe.Close()
 		return err
 	}
 	execDir := filepath.Dir(exec)
@@ -271,12 +394,25 @@ func (srv *GracefulServer) forkChild(killMaster bool, mpid int) (error) {
 		Sys:   &syscall.SysProcAttr{},
 	})
 
+	// The child has its own copy of writePipe now; our end only gets in the way of detecting EOF.
+	writePipe.Close()
+
 	if err != nil {
+		readPipe.Close()
 		return err
 	}
 
 	log.Printf("[INFO] Forked child (%v).\n", p.Pid)
 
+	if err = waitForChildReady(readPipe, DefaultReadyTimeout); err != nil {
+		return fmt.Errorf("child (%v) did not signal readiness: %v", p.Pid, err)
+	}
+
+	log.Printf("[INFO] Child (%v) is ready.\n", p.Pid)
+	if err = writePidFile(srv.PidFile, p.Pid); err != nil {
+		log.Printf("[ERR] Unable to rewrite pid file to child (%v): %v.\n", p.Pid, err)
+	}
+
 	if killMaster {
 		err = syscall.Kill(mpid, syscall.SIGTERM)
 		if err != nil {
@@ -292,14 +428,57 @@ func (srv *GracefulServer) forkChild(killMaster bool, mpid int) (error) {
 
 /*
 shutDown shuts down a server. A context (expired in DefaultAwaitTimeout time) is created as a timeout to shut
-down the server.
+down the server. Once the http.Server itself has stopped, it waits for any connections still in flight
+(e.g. handed off to a forked child) to drain, bounded by GracefulTimeout, so the old master does not vanish
+out from under requests it already accepted.
  */
 func (srv *GracefulServer) shutDown() (err error){
+	srv.setState(StateShuttingDown)
+
 	ctx, cancel := context.WithTimeout(context.Background(), DefaultAwaitTimeout)
 	expired, _ := ctx.Deadline()
 	log.Printf("[INFO] Digesting requests will be timed out at %v", expired.Format("2006-01-02 15:04:05"))
 	defer cancel()
-	return srv.Server.Shutdown(ctx)
+
+	err = srv.Server.Shutdown(ctx)
+
+	srv.waitForDrain()
+
+	srv.setState(StateTerminate)
+
+	return err
+}
+
+/*
+waitForDrain blocks until every connection tracked by wg has closed, or until timeout
+(DefaultGracefulTimeout if zero) elapses, whichever comes first. It backs GracefulServer's waitForDrain
+method and is called directly by Bootstrap's shutDown.
+ */
+func waitForDrain(wg *sync.WaitGroup, timeout time.Duration) {
+	if timeout <= 0 {
+		timeout = DefaultGracefulTimeout
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		log.Printf("[INFO] All in-flight connections drained.\n")
+	case <-time.After(timeout):
+		log.Printf("[INFO] Graceful timeout (%v) reached before all connections drained.\n", timeout)
+	}
+}
+
+/*
+waitForDrain blocks until every connection accepted through the gracefulListener has closed, or until
+GracefulTimeout (DefaultGracefulTimeout if unset) elapses, whichever comes first.
+ */
+func (srv *GracefulServer) waitForDrain() {
+	waitForDrain(&srv.connWG, srv.GracefulTimeout)
 }
 
 /*
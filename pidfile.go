@@ -0,0 +1,23 @@
+package Octopus
+
+import (
+	"io/ioutil"
+	"strconv"
+	"time"
+)
+
+// DefaultReadyTimeout bounds how long forkChild waits for a forked child to signal readiness (see
+// lifecycle.go) before giving up on the handoff.
+const DefaultReadyTimeout = 10 * time.Second
+
+/*
+writePidFile writes pid to path. It is a no-op if path is empty, so callers that never set PidFile pay
+nothing for it.
+ */
+func writePidFile(path string, pid int) error {
+	if path == "" {
+		return nil
+	}
+
+	return ioutil.WriteFile(path, []byte(strconv.Itoa(pid)), 0644)
+}
@@ -0,0 +1,61 @@
+package Octopus
+
+import (
+	"fmt"
+	"os"
+)
+
+/*
+PreSignal and PostSignal select when a hook registered with RegisterSignalHook runs relative to the
+signal's built-in handler (fork, shutdown, ...): PreSignal runs before it, PostSignal after.
+ */
+const (
+	PreSignal = iota
+	PostSignal
+)
+
+/*
+RegisterSignalHook registers fn to run whenever srv receives sig, either just before (PreSignal) or just
+after (PostSignal) the built-in handler for that signal. Hooks for the same position and signal run in
+registration order. This lets callers flush metrics, close DB pools, notify a service registry, or warm a
+cache around a reload without forking this package.
+
+Hooks must be registered before signals can arrive, so build srv with StartGraceful (or
+StartGracefulTLS/StartGracefulTLSConfig) rather than GracefulServe/GracefulServeTLS*, register hooks on
+the result, and only then call Wait to start handling signals.
+ */
+func (srv *GracefulServer) RegisterSignalHook(position int, sig os.Signal, fn func()) error {
+	switch position {
+	case PreSignal:
+		if srv.preSignalHooks == nil {
+			srv.preSignalHooks = make(map[os.Signal][]func())
+		}
+		srv.preSignalHooks[sig] = append(srv.preSignalHooks[sig], fn)
+	case PostSignal:
+		if srv.postSignalHooks == nil {
+			srv.postSignalHooks = make(map[os.Signal][]func())
+		}
+		srv.postSignalHooks[sig] = append(srv.postSignalHooks[sig], fn)
+	default:
+		return fmt.Errorf("invalid signal hook position: %d", position)
+	}
+
+	return nil
+}
+
+/*
+runSignalHooks runs every hook registered for sig at position, in registration order.
+ */
+func (srv *GracefulServer) runSignalHooks(position int, sig os.Signal) {
+	var hooks map[os.Signal][]func()
+	switch position {
+	case PreSignal:
+		hooks = srv.preSignalHooks
+	case PostSignal:
+		hooks = srv.postSignalHooks
+	}
+
+	for _, fn := range hooks[sig] {
+		fn()
+	}
+}
@@ -0,0 +1,48 @@
+package Octopus
+
+import (
+	"crypto/tls"
+	"net/http"
+)
+
+/*
+h2NextProtos are the ALPN protocols ensureALPN guarantees are present, in the order client negotiation
+should prefer them.
+ */
+var h2NextProtos = []string{"h2", "http/1.1"}
+
+/*
+ensureALPN makes sure server ends up with a TLS configuration advertising both "h2" and "http/1.1" over
+ALPN, so ServeTLS negotiates HTTP/2 whenever the client supports it. If tlsConfig is non-nil, it becomes
+server.TLSConfig (cloned, so the caller's copy is left untouched); otherwise server.TLSConfig is widened
+in place, creating one if none exists yet.
+ */
+func ensureALPN(server *http.Server, tlsConfig *tls.Config) {
+	cfg := server.TLSConfig
+	if tlsConfig != nil {
+		cfg = tlsConfig.Clone()
+	}
+	if cfg == nil {
+		cfg = &tls.Config{}
+	}
+
+	for _, proto := range h2NextProtos {
+		if !containsProto(cfg.NextProtos, proto) {
+			cfg.NextProtos = append(cfg.NextProtos, proto)
+		}
+	}
+
+	server.TLSConfig = cfg
+}
+
+/*
+containsProto reports whether protos already contains proto.
+ */
+func containsProto(protos []string, proto string) bool {
+	for _, p := range protos {
+		if p == proto {
+			return true
+		}
+	}
+	return false
+}
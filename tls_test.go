@@ -0,0 +1,77 @@
+package Octopus
+
+import (
+	"crypto/tls"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestContainsProto(t *testing.T) {
+	cases := []struct {
+		name   string
+		protos []string
+		proto  string
+		want   bool
+	}{
+		{"present", []string{"h2", "http/1.1"}, "h2", true},
+		{"absent", []string{"http/1.1"}, "h2", false},
+		{"empty list", nil, "h2", false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := containsProto(c.protos, c.proto); got != c.want {
+				t.Errorf("containsProto(%v, %q) = %v, want %v", c.protos, c.proto, got, c.want)
+			}
+		})
+	}
+}
+
+func TestEnsureALPN(t *testing.T) {
+	cases := []struct {
+		name      string
+		server    *http.Server
+		tlsConfig *tls.Config
+		want      []string
+	}{
+		{
+			name:   "nil server.TLSConfig and nil override",
+			server: &http.Server{},
+			want:   []string{"h2", "http/1.1"},
+		},
+		{
+			name:   "existing server.TLSConfig without ALPN",
+			server: &http.Server{TLSConfig: &tls.Config{}},
+			want:   []string{"h2", "http/1.1"},
+		},
+		{
+			name:   "existing NextProtos already has h2",
+			server: &http.Server{TLSConfig: &tls.Config{NextProtos: []string{"h2"}}},
+			want:   []string{"h2", "http/1.1"},
+		},
+		{
+			name:      "override tlsConfig is cloned, not mutated in place",
+			server:    &http.Server{},
+			tlsConfig: &tls.Config{NextProtos: []string{"custom"}},
+			want:      []string{"custom", "h2", "http/1.1"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ensureALPN(c.server, c.tlsConfig)
+
+			if c.server.TLSConfig == nil {
+				t.Fatal("expected server.TLSConfig to be set")
+			}
+			if !reflect.DeepEqual(c.server.TLSConfig.NextProtos, c.want) {
+				t.Errorf("NextProtos = %v, want %v", c.server.TLSConfig.NextProtos, c.want)
+			}
+
+			if c.tlsConfig != nil && reflect.DeepEqual(c.tlsConfig.NextProtos, c.want) {
+				t.Error("ensureALPN mutated the caller's tlsConfig in place instead of cloning it")
+			}
+		})
+	}
+}